@@ -0,0 +1,383 @@
+/*
+ * MinIO Cloud Storage, (C) 2020 MinIO, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openid
+
+import (
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	xnet "github.com/minio/minio/pkg/net"
+)
+
+// kidRefreshInterval is the minimum spacing between two kid-miss triggered
+// refreshes for the same provider, so that a token carrying an unknown (or
+// attacker-supplied) `kid` cannot be used to force repeated JWKS fetches.
+const kidRefreshInterval = time.Minute
+
+// defaultJWKSMaxAge is used to schedule the next background refresh when the
+// JWKS response carries neither a Cache-Control max-age nor an Expires
+// header.
+const defaultJWKSMaxAge = 15 * time.Minute
+
+// JWKSMetrics is a point-in-time snapshot of a provider's JWKS cache
+// counters, exposed so operators can wire them into their monitoring of
+// choice.
+type JWKSMetrics struct {
+	RefreshOK   uint64
+	RefreshErr  uint64
+	CacheHits   uint64
+	CacheMisses uint64
+}
+
+// jwksCache holds the cached key set for a provider along with everything
+// needed to keep it fresh: the validator (ETag) for the next conditional
+// GET, single-flight coordination so concurrent misses collapse into one
+// fetch, and a rate limit on kid-triggered refreshes.
+type jwksCache struct {
+	mu   sync.RWMutex
+	keys map[string]crypto.PublicKey
+
+	// algs holds the `alg` each JWK advertised, keyed by kid, so Validate
+	// can reject a token signed with a different algorithm than its key
+	// was published under.
+	algs map[string]string
+
+	etag   string
+	expiry time.Time
+
+	lastKidRefreshMu sync.Mutex
+	lastKidRefresh   time.Time
+
+	refreshMu   sync.Mutex
+	refreshDone chan struct{} // non-nil while a refresh is in flight
+	refreshErr  error
+
+	refreshOK       uint64
+	refreshErrCount uint64 // incremented atomically after refreshMu is released
+	cacheHits       uint64
+	cacheMisses     uint64
+
+	startOnce sync.Once
+	stop      chan struct{}
+}
+
+func (r *JWKSArgs) ensureCache() *jwksCache {
+	if r.cache == nil {
+		r.cache = &jwksCache{stop: make(chan struct{})}
+	}
+	return r.cache
+}
+
+// url returns the JWKS URL, synchronized with doRefresh - which, for a
+// ConfigURL-based provider, discovers and sets it the first time a refresh
+// runs, concurrently with any caller reading it directly off of r.
+func (r *JWKSArgs) url() *xnet.URL {
+	c := r.ensureCache()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return r.URL
+}
+
+// discoveryDoc returns the document discovered via ConfigURL, synchronized
+// with the same doRefresh write as url().
+func (r *JWKSArgs) discoveryDoc() DiscoveryDoc {
+	c := r.ensureCache()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return r.DiscoveryDoc
+}
+
+// snapshot returns a copy of r with the fields doRefresh may mutate in the
+// background (URL, DiscoveryDoc, publicKeys) read under the same lock it
+// writes them with, rather than racing a raw `*r` struct copy.
+func (r *JWKSArgs) snapshot() JWKSArgs {
+	c := r.ensureCache()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return *r
+}
+
+// lookupKey returns the cached public key for kid, recording a cache hit or
+// miss for Metrics.
+func (r *JWKSArgs) lookupKey(kid string) (crypto.PublicKey, bool) {
+	c := r.ensureCache()
+	c.mu.RLock()
+	key, ok := c.keys[kid]
+	c.mu.RUnlock()
+
+	if ok {
+		atomic.AddUint64(&c.cacheHits, 1)
+	} else {
+		atomic.AddUint64(&c.cacheMisses, 1)
+	}
+	return key, ok
+}
+
+// lookupAlg returns the `alg` the JWK for kid was published with, if any.
+func (r *JWKSArgs) lookupAlg(kid string) (string, bool) {
+	c := r.ensureCache()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	alg, ok := c.algs[kid]
+	return alg, ok
+}
+
+// refreshForKid triggers a refresh in response to an unknown kid, subject to
+// kidRefreshInterval - this keeps a forged kid from being usable to hammer
+// the JWKS endpoint.
+func (r *JWKSArgs) refreshForKid() {
+	c := r.ensureCache()
+
+	c.lastKidRefreshMu.Lock()
+	if time.Since(c.lastKidRefresh) < kidRefreshInterval {
+		c.lastKidRefreshMu.Unlock()
+		return
+	}
+	c.lastKidRefresh = time.Now()
+	c.lastKidRefreshMu.Unlock()
+
+	r.refresh()
+}
+
+// refresh fetches the latest JWKS, coalescing concurrent callers into a
+// single in-flight request.
+func (r *JWKSArgs) refresh() error {
+	c := r.ensureCache()
+
+	c.refreshMu.Lock()
+	if c.refreshDone != nil {
+		done := c.refreshDone
+		c.refreshMu.Unlock()
+		<-done
+		return c.refreshErr
+	}
+	done := make(chan struct{})
+	c.refreshDone = done
+	c.refreshMu.Unlock()
+
+	err := r.doRefresh()
+
+	c.refreshMu.Lock()
+	c.refreshErr = err
+	c.refreshDone = nil
+	c.refreshMu.Unlock()
+	close(done)
+
+	if err != nil {
+		atomic.AddUint64(&c.refreshErrCount, 1)
+	} else {
+		atomic.AddUint64(&c.refreshOK, 1)
+	}
+
+	return err
+}
+
+// doRefresh performs the actual conditional GET against the JWKS URL,
+// resolving it via OIDC discovery first if it isn't already known, and
+// updates the cache on success. A 304 Not Modified response leaves the
+// cached keys untouched and only resets the refresh deadline.
+func (r *JWKSArgs) doRefresh() error {
+	client := &http.Client{}
+	if r.transport != nil {
+		client.Transport = r.transport
+	}
+
+	c := r.ensureCache()
+
+	jwksURL := r.url()
+	if jwksURL == nil && r.ConfigURL != nil {
+		doc, err := lookupDiscoveryDoc(client, r.ConfigURL.String())
+		if err != nil {
+			return err
+		}
+		u, err := xnet.ParseURL(doc.JWKSURI)
+		if err != nil {
+			return err
+		}
+		c.mu.Lock()
+		r.DiscoveryDoc = doc
+		r.URL = u
+		c.mu.Unlock()
+		jwksURL = u
+	}
+	if jwksURL == nil {
+		return nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, jwksURL.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	c.mu.RLock()
+	etag := c.etag
+	c.mu.RUnlock()
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.closeRespFn(resp.Body)
+
+	maxAge := maxAgeFromHeaders(resp.Header)
+
+	if resp.StatusCode == http.StatusNotModified {
+		c.mu.Lock()
+		c.expiry = time.Now().Add(maxAge)
+		c.mu.Unlock()
+		return nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(resp.Status)
+	}
+
+	var jwk JWKS
+	if err = json.NewDecoder(resp.Body).Decode(&jwk); err != nil {
+		return err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(jwk.Keys))
+	algs := make(map[string]string, len(jwk.Keys))
+	for _, key := range jwk.Keys {
+		var publicKey crypto.PublicKey
+		if key.Kty == "oct" {
+			// Symmetric (HMAC) signing key - there is no "public" half,
+			// the shared secret itself is what the keyFunc hands back to
+			// jwt-go for HS256/384/512 verification.
+			secret, err := base64.RawURLEncoding.DecodeString(key.K)
+			if err != nil {
+				return fmt.Errorf("openid: invalid oct JWK %q: %w", key.Kid, err)
+			}
+			publicKey = secret
+		} else {
+			var err error
+			publicKey, err = key.DecodePublicKey()
+			if err != nil {
+				return err
+			}
+		}
+		keys[key.Kid] = publicKey
+		if key.Alg != "" {
+			algs[key.Kid] = key.Alg
+		}
+	}
+
+	c.mu.Lock()
+	c.keys = keys
+	c.algs = algs
+	c.etag = resp.Header.Get("ETag")
+	c.expiry = time.Now().Add(maxAge)
+	// Older callers read publicKeys directly; keep it mirrored. Assigned
+	// under c.mu alongside URL/DiscoveryDoc above, since snapshot (used by
+	// Config.Provider/MarshalJSON) reads all three under the same lock.
+	r.publicKeys = keys
+	c.mu.Unlock()
+
+	return nil
+}
+
+// maxAgeFromHeaders derives how long a JWKS response can be trusted for from
+// its Cache-Control max-age directive, falling back to Expires and finally
+// to defaultJWKSMaxAge.
+func maxAgeFromHeaders(h http.Header) time.Duration {
+	if cc := h.Get("Cache-Control"); cc != "" {
+		for _, directive := range strings.Split(cc, ",") {
+			directive = strings.TrimSpace(directive)
+			const prefix = "max-age="
+			if strings.HasPrefix(directive, prefix) {
+				if secs, err := strconv.Atoi(strings.TrimPrefix(directive, prefix)); err == nil {
+					return time.Duration(secs) * time.Second
+				}
+			}
+		}
+	}
+	if exp := h.Get("Expires"); exp != "" {
+		if t, err := http.ParseTime(exp); err == nil {
+			if d := time.Until(t); d > 0 {
+				return d
+			}
+		}
+	}
+	return defaultJWKSMaxAge
+}
+
+// startBackgroundRefresh launches (at most once) a goroutine that keeps the
+// cache warm ahead of expiry, driven by the max-age/Expires the provider
+// returned on the last fetch.
+func (r *JWKSArgs) startBackgroundRefresh() {
+	c := r.ensureCache()
+	c.startOnce.Do(func() {
+		go func() {
+			for {
+				c.mu.RLock()
+				expiry := c.expiry
+				c.mu.RUnlock()
+
+				wait := time.Until(expiry)
+				if wait <= 0 {
+					wait = defaultJWKSMaxAge
+				}
+
+				select {
+				case <-time.After(wait):
+					r.refresh()
+				case <-c.stop:
+					return
+				}
+			}
+		}()
+	})
+}
+
+// stopBackgroundRefresh stops the background refresh goroutine, if running.
+func (r *JWKSArgs) stopBackgroundRefresh() {
+	if r.cache == nil {
+		return
+	}
+	select {
+	case <-r.cache.stop:
+		// already stopped
+	default:
+		close(r.cache.stop)
+	}
+}
+
+// Metrics returns a snapshot of this provider's JWKS cache counters.
+func (r *JWKSArgs) Metrics() JWKSMetrics {
+	if r.cache == nil {
+		return JWKSMetrics{}
+	}
+	return JWKSMetrics{
+		RefreshOK:   atomic.LoadUint64(&r.cache.refreshOK),
+		RefreshErr:  atomic.LoadUint64(&r.cache.refreshErrCount),
+		CacheHits:   atomic.LoadUint64(&r.cache.cacheHits),
+		CacheMisses: atomic.LoadUint64(&r.cache.cacheMisses),
+	}
+}
@@ -0,0 +1,127 @@
+/*
+ * MinIO Cloud Storage, (C) 2020 MinIO, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openid
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	xnet "github.com/minio/minio/pkg/net"
+)
+
+func TestMaxAgeFromHeaders(t *testing.T) {
+	tests := []struct {
+		name string
+		h    http.Header
+		want time.Duration
+	}{
+		{
+			name: "cache-control max-age",
+			h:    http.Header{"Cache-Control": []string{"public, max-age=120"}},
+			want: 120 * time.Second,
+		},
+		{
+			name: "expires fallback",
+			h:    http.Header{"Expires": []string{time.Now().Add(time.Hour).Format(http.TimeFormat)}},
+			want: time.Hour,
+		},
+		{
+			name: "no headers falls back to default",
+			h:    http.Header{},
+			want: defaultJWKSMaxAge,
+		},
+		{
+			name: "expires in the past falls back to default",
+			h:    http.Header{"Expires": []string{time.Now().Add(-time.Hour).Format(http.TimeFormat)}},
+			want: defaultJWKSMaxAge,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := maxAgeFromHeaders(test.h)
+			// Allow a little slack for the Expires-based cases, which are
+			// derived from time.Until at comparison time.
+			diff := got - test.want
+			if diff < 0 {
+				diff = -diff
+			}
+			if diff > time.Second {
+				t.Fatalf("maxAgeFromHeaders() = %v, want ~%v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDoRefreshConditionalGet(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte(`{"keys":[]}`))
+	}))
+	defer ts.Close()
+
+	u, err := xnet.ParseURL(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := &JWKSArgs{URL: u, closeRespFn: func(c io.ReadCloser) { c.Close() }}
+
+	if err := args.doRefresh(); err != nil {
+		t.Fatalf("initial doRefresh: %v", err)
+	}
+	if err := args.doRefresh(); err != nil {
+		t.Fatalf("conditional doRefresh: %v", err)
+	}
+	if atomic.LoadInt32(&requests) != 2 {
+		t.Fatalf("expected 2 requests to the JWKS endpoint, got %d", requests)
+	}
+	if args.cache.etag != `"v1"` {
+		t.Fatalf("expected cached ETag to survive a 304, got %q", args.cache.etag)
+	}
+}
+
+func TestRefreshForKidIsRateLimited(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write([]byte(`{"keys":[]}`))
+	}))
+	defer ts.Close()
+
+	u, err := xnet.ParseURL(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := &JWKSArgs{URL: u, closeRespFn: func(c io.ReadCloser) { c.Close() }}
+
+	args.refreshForKid()
+	args.refreshForKid()
+	if atomic.LoadInt32(&requests) != 1 {
+		t.Fatalf("expected the second refreshForKid within kidRefreshInterval to be rate limited, got %d requests", requests)
+	}
+}
@@ -0,0 +1,125 @@
+/*
+ * MinIO Cloud Storage, (C) 2020 MinIO, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openid
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	jwtgo "github.com/dgrijalva/jwt-go"
+)
+
+// defaultClockSkew bounds how far a token's nbf/iat claims may diverge from
+// local time before it is rejected, accommodating modest clock drift
+// between MinIO and the identity provider.
+const defaultClockSkew = time.Minute
+
+// policyClaimKey is the claim Validate's returned claim set carries a
+// provider's policy-mapped value under, regardless of which IdP claim it
+// was read from. This is the name existing STS handlers already key off of.
+const policyClaimKey = "policy"
+
+// validateClaims checks the RFC 7519 claims that signature verification
+// alone doesn't cover: issuer, audience/authorized-party, not-before and
+// issued-at.
+func (r *JWKSArgs) validateClaims(claims jwtgo.MapClaims) error {
+	if iss := r.issuer(); iss != "" {
+		if got, _ := claims["iss"].(string); got != iss {
+			return fmt.Errorf("openid: token issuer %q does not match configured issuer %q", got, iss)
+		}
+	}
+
+	if r.ClientID != "" && !audienceContains(claims, r.ClientID) {
+		return fmt.Errorf("openid: token is not intended for client %q", r.ClientID)
+	}
+
+	skew := r.ClockSkew
+	if skew <= 0 {
+		skew = defaultClockSkew
+	}
+	now := time.Now().UTC()
+
+	if nbf, ok := claims["nbf"]; ok {
+		nbfAt, err := expToInt64(nbf)
+		if err != nil {
+			return err
+		}
+		if now.Add(skew).Before(time.Unix(nbfAt, 0).UTC()) {
+			return fmt.Errorf("openid: token is not valid yet")
+		}
+	}
+
+	if iat, ok := claims["iat"]; ok {
+		iatAt, err := expToInt64(iat)
+		if err != nil {
+			return err
+		}
+		if time.Unix(iatAt, 0).UTC().After(now.Add(skew)) {
+			return fmt.Errorf("openid: token was issued in the future")
+		}
+	}
+
+	return nil
+}
+
+// audienceContains reports whether clientID appears in the token's `aud`
+// claim (a single string or a list of strings) or, failing that, its `azp`
+// (authorized party) claim - some providers put the consuming client there
+// when `aud` lists several resource servers.
+func audienceContains(claims jwtgo.MapClaims, clientID string) bool {
+	switch aud := claims["aud"].(type) {
+	case string:
+		if aud == clientID {
+			return true
+		}
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok && s == clientID {
+				return true
+			}
+		}
+	}
+	azp, _ := claims["azp"].(string)
+	return azp == clientID
+}
+
+// applyPolicyClaim resolves the operator-configured claim - a plain claim
+// name, or a dotted path into a nested claim such as "realm_access.roles" -
+// and, if found, republishes it under policyClaimKey so STS handlers can
+// drive policy attachment from whatever claim the IdP actually sends,
+// instead of a hardcoded name.
+func (r *JWKSArgs) applyPolicyClaim(claims jwtgo.MapClaims) {
+	name := r.ClaimName
+	if name == "" || name == policyClaimKey {
+		return
+	}
+
+	var cur interface{} = map[string]interface{}(claims)
+	for _, part := range strings.Split(name, ".") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return
+		}
+		cur, ok = m[part]
+		if !ok {
+			return
+		}
+	}
+
+	claims[policyClaimKey] = cur
+}
@@ -0,0 +1,117 @@
+/*
+ * MinIO Cloud Storage, (C) 2020 MinIO, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openid
+
+import (
+	"testing"
+	"time"
+
+	jwtgo "github.com/dgrijalva/jwt-go"
+)
+
+func TestValidateClaimsIssuerAndAudience(t *testing.T) {
+	args := &JWKSArgs{Issuer: "https://idp.example.com", ClientID: "my-client"}
+
+	claims := jwtgo.MapClaims{
+		"iss": "https://idp.example.com",
+		"aud": "my-client",
+	}
+	if err := args.validateClaims(claims); err != nil {
+		t.Fatalf("expected matching iss/aud to validate, got %v", err)
+	}
+
+	claims["iss"] = "https://evil.example.com"
+	if err := args.validateClaims(claims); err == nil {
+		t.Fatal("expected mismatched issuer to be rejected")
+	}
+
+	claims["iss"] = "https://idp.example.com"
+	claims["aud"] = "someone-else"
+	if err := args.validateClaims(claims); err == nil {
+		t.Fatal("expected mismatched audience to be rejected")
+	}
+}
+
+func TestAudienceContains(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims jwtgo.MapClaims
+		want   bool
+	}{
+		{"string aud match", jwtgo.MapClaims{"aud": "client-a"}, true},
+		{"string aud mismatch", jwtgo.MapClaims{"aud": "client-b"}, false},
+		{"list aud match", jwtgo.MapClaims{"aud": []interface{}{"client-x", "client-a"}}, true},
+		{"azp fallback", jwtgo.MapClaims{"aud": []interface{}{"resource-server"}, "azp": "client-a"}, true},
+		{"no match anywhere", jwtgo.MapClaims{"aud": []interface{}{"resource-server"}, "azp": "client-b"}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := audienceContains(test.claims, "client-a"); got != test.want {
+				t.Fatalf("audienceContains() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestValidateClaimsClockSkew(t *testing.T) {
+	args := &JWKSArgs{ClockSkew: time.Second}
+	now := time.Now().UTC()
+
+	claims := jwtgo.MapClaims{"nbf": float64(now.Add(time.Hour).Unix())}
+	if err := args.validateClaims(claims); err == nil {
+		t.Fatal("expected a future nbf beyond the clock skew to be rejected")
+	}
+
+	claims = jwtgo.MapClaims{"iat": float64(now.Add(time.Hour).Unix())}
+	if err := args.validateClaims(claims); err == nil {
+		t.Fatal("expected an iat in the future beyond the clock skew to be rejected")
+	}
+
+	claims = jwtgo.MapClaims{"nbf": float64(now.Add(-time.Hour).Unix())}
+	if err := args.validateClaims(claims); err != nil {
+		t.Fatalf("expected a past nbf to validate, got %v", err)
+	}
+}
+
+func TestApplyPolicyClaim(t *testing.T) {
+	args := &JWKSArgs{ClaimName: "realm_access.roles"}
+	claims := jwtgo.MapClaims{
+		"realm_access": map[string]interface{}{
+			"roles": []interface{}{"readwrite"},
+		},
+	}
+	args.applyPolicyClaim(claims)
+	if _, ok := claims[policyClaimKey]; !ok {
+		t.Fatal("expected dotted claim path to be resolved into policyClaimKey")
+	}
+
+	// A missing path leaves the claim set untouched.
+	args = &JWKSArgs{ClaimName: "does.not.exist"}
+	claims = jwtgo.MapClaims{"iss": "https://idp.example.com"}
+	args.applyPolicyClaim(claims)
+	if _, ok := claims[policyClaimKey]; ok {
+		t.Fatal("expected a missing claim path to leave policyClaimKey unset")
+	}
+
+	// An empty ClaimName is a no-op.
+	args = &JWKSArgs{}
+	claims = jwtgo.MapClaims{}
+	args.applyPolicyClaim(claims)
+	if _, ok := claims[policyClaimKey]; ok {
+		t.Fatal("expected an empty ClaimName to be a no-op")
+	}
+}
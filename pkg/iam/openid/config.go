@@ -0,0 +1,459 @@
+/*
+ * MinIO Cloud Storage, (C) 2018-2020 MinIO, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openid
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio/pkg/env"
+	xnet "github.com/minio/minio/pkg/net"
+)
+
+// Environment variables recognized for OpenID configuration. A single,
+// unnamed ("_default") provider is configured directly via the
+// Env*-prefixed keys below. Alternatively, providers are listed in
+// EnvIdentityOpenIDProviders and each is configured via the same keys
+// suffixed with "_<NAME>" (upper-cased), e.g.
+// MINIO_IDENTITY_OPENID_CONFIG_URL_OKTA; the unsuffixed keys, and so the
+// "_default" provider, are then not used.
+const (
+	EnvIAMJWKSURL = "MINIO_IAM_JWKS_URL"
+
+	EnvIdentityOpenIDProviders    = "MINIO_IDENTITY_OPENID_PROVIDERS"
+	EnvIdentityOpenIDConfigURL    = "MINIO_IDENTITY_OPENID_CONFIG_URL"
+	EnvIdentityOpenIDClientID     = "MINIO_IDENTITY_OPENID_CLIENT_ID"
+	EnvIdentityOpenIDClientSecret = "MINIO_IDENTITY_OPENID_CLIENT_SECRET"
+	EnvIdentityOpenIDIssuer       = "MINIO_IDENTITY_OPENID_ISSUER"
+	EnvIdentityOpenIDClaimName    = "MINIO_IDENTITY_OPENID_CLAIM_NAME"
+	EnvIdentityOpenIDClockSkew    = "MINIO_IDENTITY_OPENID_CLOCK_SKEW"
+
+	EnvIdentityOpenIDIntrospectionEnabled  = "MINIO_IDENTITY_OPENID_INTROSPECTION_ENABLED"
+	EnvIdentityOpenIDIntrospectionEndpoint = "MINIO_IDENTITY_OPENID_INTROSPECTION_ENDPOINT"
+)
+
+// defaultProvider is the name given to the single OpenID provider configured
+// via the unsuffixed environment variables, kept around so error messages
+// and the admin API have something nicer to print than the empty string.
+const defaultProvider = "_default"
+
+// Config holds the set of configured OpenID providers, keyed by an operator
+// supplied name (e.g. "google", "okta"). Validate routes an access token to
+// the right provider based on the issuer embedded in its claims, so that a
+// deployment can trust more than one IdP at a time.
+type Config struct {
+	lock sync.RWMutex
+
+	// providers is keyed by provider name.
+	providers map[string]*JWKSArgs
+	// issuers indexes the same providers by their configured/discovered
+	// issuer so a token's `iss` claim can be resolved directly.
+	issuers map[string]*JWKSArgs
+
+	transport   *http.Transport
+	closeRespFn func(io.ReadCloser)
+
+	// jwePrivateKey decrypts JWE-wrapped ID tokens before they are routed
+	// to a provider, see EnvIAMJWEPrivateKey and Config.decryptJWE.
+	jwePrivateKey crypto.PrivateKey
+}
+
+// NewConfig returns an empty provider configuration. Providers are added via
+// AddProvider, typically from LookupConfig or the IAM config CRUD handlers.
+func NewConfig(transport *http.Transport, closeRespFn func(io.ReadCloser)) *Config {
+	return &Config{
+		providers:   make(map[string]*JWKSArgs),
+		issuers:     make(map[string]*JWKSArgs),
+		transport:   transport,
+		closeRespFn: closeRespFn,
+	}
+}
+
+// MarshalJSON serializes the configured providers, keyed by name, so a
+// Config can be persisted as IAM config by the admin API and later restored
+// with UnmarshalJSON. Runtime-only state (the JWKS cache, transport, etc.)
+// is not included; it is rebuilt by AddProvider on load.
+func (c *Config) MarshalJSON() ([]byte, error) {
+	c.lock.RLock()
+	byName := make(map[string]*JWKSArgs, len(c.providers))
+	for name, args := range c.providers {
+		byName[name] = args
+	}
+	c.lock.RUnlock()
+
+	// snapshot each provider through its own JWKS cache lock, rather than a
+	// raw struct copy, since doRefresh mutates URL/DiscoveryDoc/publicKeys
+	// from a background goroutine concurrently with this call.
+	providers := make(map[string]JWKSArgs, len(byName))
+	for name, args := range byName {
+		providers[name] = args.snapshot()
+	}
+	return json.Marshal(providers)
+}
+
+// UnmarshalJSON restores a set of providers persisted via MarshalJSON,
+// adding each one through AddProvider - so its JWKS is (re-)fetched,
+// discovering it first if configured via ConfigURL, exactly as if an
+// operator had just added it via the IAM config CRUD handlers.
+func (c *Config) UnmarshalJSON(data []byte) error {
+	var providers map[string]JWKSArgs
+	if err := json.Unmarshal(data, &providers); err != nil {
+		return err
+	}
+	for name, args := range providers {
+		if err := c.AddProvider(name, args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Enabled returns true if at least one provider is configured.
+func (c *Config) Enabled() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return len(c.providers) > 0
+}
+
+// ProviderNames returns the names of all configured providers.
+func (c *Config) ProviderNames() []string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	names := make([]string, 0, len(c.providers))
+	for name := range c.providers {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Metrics returns a snapshot of each configured provider's JWKS cache
+// counters, keyed by provider name.
+func (c *Config) Metrics() map[string]JWKSMetrics {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	out := make(map[string]JWKSMetrics, len(c.providers))
+	for name, args := range c.providers {
+		out[name] = args.Metrics()
+	}
+	return out
+}
+
+// soleProvider returns the single configured provider, for callers (like the
+// opaque-token introspection fallback) that have no way to route on a
+// token's issuer and so can only auto-select a provider when exactly one is
+// configured.
+func (c *Config) soleProvider() (*JWKSArgs, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	switch len(c.providers) {
+	case 0:
+		return nil, errors.New("openid: no providers configured")
+	case 1:
+		for _, args := range c.providers {
+			return args, nil
+		}
+	}
+	return nil, errors.New("openid: token cannot be routed to a provider and more than one is configured; use ValidateWithProvider")
+}
+
+// providerByName returns the named provider, for callers that already know
+// (out of band) which provider issued a token that can't be routed on its
+// own, e.g. an opaque token in a multi-provider deployment.
+func (c *Config) providerByName(name string) (*JWKSArgs, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	args, ok := c.providers[name]
+	return args, ok
+}
+
+// Provider returns a copy of the named provider's arguments.
+func (c *Config) Provider(name string) (JWKSArgs, bool) {
+	c.lock.RLock()
+	args, ok := c.providers[name]
+	c.lock.RUnlock()
+	if !ok {
+		return JWKSArgs{}, false
+	}
+	// snapshot, not a raw struct copy: doRefresh mutates URL/DiscoveryDoc/
+	// publicKeys from a background goroutine under the JWKS cache's lock.
+	return args.snapshot(), true
+}
+
+// AddProvider registers (or replaces) a named OpenID provider. The JWKS is
+// fetched immediately - resolving OIDC discovery first when ConfigURL is set
+// - so configuration mistakes surface at admin-API time rather than on the
+// next token validation.
+func (c *Config) AddProvider(name string, args JWKSArgs) error {
+	if name == "" {
+		return errors.New("openid: provider name cannot be empty")
+	}
+
+	args.transport = c.transport
+	args.closeRespFn = c.closeRespFn
+	if err := args.PopulatePublicKey(); err != nil {
+		return fmt.Errorf("openid: unable to configure provider %q: %w", name, err)
+	}
+	// Pre-populate the introspection cache here, synchronously and before
+	// the provider is published below, the same way PopulatePublicKey
+	// readies the JWKS cache - introspectToken's lazy ensureIntrospectionCache
+	// call is otherwise reachable concurrently from the first requests an
+	// introspection-enabled (or opaque-token) provider serves.
+	args.ensureIntrospectionCache()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.providers == nil {
+		c.providers = make(map[string]*JWKSArgs)
+	}
+	if c.issuers == nil {
+		c.issuers = make(map[string]*JWKSArgs)
+	}
+	if old, ok := c.providers[name]; ok {
+		if iss := old.issuer(); iss != "" {
+			delete(c.issuers, iss)
+		}
+		old.stopBackgroundRefresh()
+	}
+	c.providers[name] = &args
+	if iss := args.issuer(); iss != "" {
+		c.issuers[iss] = &args
+	}
+	return nil
+}
+
+// RemoveProvider removes a previously added provider, if any.
+func (c *Config) RemoveProvider(name string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	args, ok := c.providers[name]
+	if !ok {
+		return
+	}
+	delete(c.providers, name)
+	if iss := args.issuer(); iss != "" {
+		delete(c.issuers, iss)
+	}
+	args.stopBackgroundRefresh()
+}
+
+// providerForClaims picks the provider that should validate a token carrying
+// the given (unverified) claims, using the `iss` claim when more than one
+// provider is configured.
+func (c *Config) providerForClaims(claims map[string]interface{}) (*JWKSArgs, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	if len(c.providers) == 0 {
+		return nil, errors.New("openid: no providers configured")
+	}
+
+	iss, _ := claims["iss"].(string)
+	if iss != "" {
+		if args, ok := c.issuers[iss]; ok {
+			return args, nil
+		}
+		if len(c.providers) > 1 {
+			return nil, fmt.Errorf("openid: no configured provider for issuer %q", iss)
+		}
+	} else if len(c.providers) > 1 {
+		return nil, errors.New("openid: token has no iss claim and more than one provider is configured")
+	}
+
+	// Exactly one provider is configured - fall back to it, covering a
+	// token with no (or an unmatched) `iss` claim in a single-provider
+	// deployment. With more than one provider configured this is
+	// unreachable; the branches above return an error first.
+	for _, args := range c.providers {
+		return args, nil
+	}
+	return nil, errors.New("openid: no providers configured")
+}
+
+// issuer returns the issuer to index this provider under: the one
+// discovered via OIDC discovery if available, otherwise the configured
+// issuer/config URL.
+func (r *JWKSArgs) issuer() string {
+	if doc := r.discoveryDoc(); doc.Issuer != "" {
+		return doc.Issuer
+	}
+	if r.Issuer != "" {
+		return r.Issuer
+	}
+	if r.ConfigURL != nil {
+		return r.ConfigURL.String()
+	}
+	return ""
+}
+
+// defaultValidMethods is accepted for a provider that wasn't configured via
+// discovery (e.g. a directly configured JWKS URL, with no
+// id_token_signing_alg_values_supported to enforce instead).
+var defaultValidMethods = []string{
+	"RS256", "RS384", "RS512",
+	"ES256", "ES384", "ES512",
+	"HS256", "HS384", "HS512",
+}
+
+// validMethods returns the signing algorithms Validate should accept for a
+// token from this provider: the ones its discovery document advertised via
+// id_token_signing_alg_values_supported, or defaultValidMethods when
+// discovery wasn't used.
+func (r *JWKSArgs) validMethods() []string {
+	if algs := r.discoveryDoc().IDTokenSigningAlgValuesSupported; len(algs) > 0 {
+		return algs
+	}
+	return defaultValidMethods
+}
+
+// LookupConfig creates a Config from the environment, overriding the passed
+// in defaults. With no provider list configured it behaves as a single
+// ("_default") provider, populated either from a direct JWKS URL (legacy) or
+// from an issuer URL via OIDC discovery. Set EnvIdentityOpenIDProviders to a
+// comma separated list of names to configure more than one provider.
+func LookupConfig(args JWKSArgs, transport *http.Transport, closeRespFn func(io.ReadCloser)) (*Config, error) {
+	cfg := NewConfig(transport, closeRespFn)
+
+	if pemStr := env.Get(EnvIAMJWEPrivateKey, ""); pemStr != "" {
+		key, err := parseJWEPrivateKey([]byte(pemStr))
+		if err != nil {
+			return nil, err
+		}
+		cfg.jwePrivateKey = key
+	}
+
+	names := env.Get(EnvIdentityOpenIDProviders, "")
+	if names == "" {
+		return lookupDefaultProvider(cfg, args)
+	}
+
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		pargs, err := providerArgsFromEnv(name)
+		if err != nil {
+			return nil, err
+		}
+		if pargs.URL == nil && pargs.ConfigURL == nil {
+			continue
+		}
+		if err := cfg.AddProvider(name, pargs); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+func lookupDefaultProvider(cfg *Config, args JWKSArgs) (*Config, error) {
+	var jwksURLStr string
+	if args.URL != nil {
+		jwksURLStr = args.URL.String()
+	}
+
+	configURL := env.Get(EnvIdentityOpenIDConfigURL, "")
+	jwksURL := env.Get(EnvIAMJWKSURL, jwksURLStr)
+
+	switch {
+	case configURL != "":
+		u, err := xnet.ParseURL(configURL)
+		if err != nil {
+			return nil, err
+		}
+		args.ConfigURL = u
+	case jwksURL != "":
+		u, err := xnet.ParseURL(jwksURL)
+		if err != nil {
+			return nil, err
+		}
+		args.URL = u
+	default:
+		// Nothing configured - OpenID stays disabled.
+		return cfg, nil
+	}
+
+	args.ClientID = env.Get(EnvIdentityOpenIDClientID, args.ClientID)
+	args.ClientSecret = env.Get(EnvIdentityOpenIDClientSecret, args.ClientSecret)
+	args.Issuer = env.Get(EnvIdentityOpenIDIssuer, args.Issuer)
+	args.ClaimName = env.Get(EnvIdentityOpenIDClaimName, args.ClaimName)
+	if skew, err := clockSkewFromEnv(EnvIdentityOpenIDClockSkew); err != nil {
+		return nil, err
+	} else if skew > 0 {
+		args.ClockSkew = skew
+	}
+	args.IntrospectionEnabled = env.Get(EnvIdentityOpenIDIntrospectionEnabled, "") == "on"
+	args.IntrospectionEndpoint = env.Get(EnvIdentityOpenIDIntrospectionEndpoint, args.IntrospectionEndpoint)
+
+	if err := cfg.AddProvider(defaultProvider, args); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func providerArgsFromEnv(name string) (JWKSArgs, error) {
+	var args JWKSArgs
+	suffix := "_" + strings.ToUpper(name)
+
+	if configURL := env.Get(EnvIdentityOpenIDConfigURL+suffix, ""); configURL != "" {
+		u, err := xnet.ParseURL(configURL)
+		if err != nil {
+			return args, err
+		}
+		args.ConfigURL = u
+	} else if jwksURL := env.Get(EnvIAMJWKSURL+suffix, ""); jwksURL != "" {
+		u, err := xnet.ParseURL(jwksURL)
+		if err != nil {
+			return args, err
+		}
+		args.URL = u
+	}
+
+	args.ClientID = env.Get(EnvIdentityOpenIDClientID+suffix, "")
+	args.ClientSecret = env.Get(EnvIdentityOpenIDClientSecret+suffix, "")
+	args.Issuer = env.Get(EnvIdentityOpenIDIssuer+suffix, "")
+	args.ClaimName = env.Get(EnvIdentityOpenIDClaimName+suffix, "")
+	skew, err := clockSkewFromEnv(EnvIdentityOpenIDClockSkew + suffix)
+	if err != nil {
+		return args, err
+	}
+	args.ClockSkew = skew
+	args.IntrospectionEnabled = env.Get(EnvIdentityOpenIDIntrospectionEnabled+suffix, "") == "on"
+	args.IntrospectionEndpoint = env.Get(EnvIdentityOpenIDIntrospectionEndpoint+suffix, "")
+	return args, nil
+}
+
+// clockSkewFromEnv parses a clock skew duration (in seconds) from envKey, if
+// set.
+func clockSkewFromEnv(envKey string) (time.Duration, error) {
+	v := env.Get(envKey, "")
+	if v == "" {
+		return 0, nil
+	}
+	secs, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("openid: invalid %s: %w", envKey, err)
+	}
+	return time.Duration(secs) * time.Second, nil
+}
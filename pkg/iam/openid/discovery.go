@@ -0,0 +1,77 @@
+/*
+ * MinIO Cloud Storage, (C) 2018-2020 MinIO, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openid
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// discoveryDocPath is appended to the configured issuer to form the
+// well-known OpenID provider configuration URL, as per
+// https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderConfig
+const discoveryDocPath = "/.well-known/openid-configuration"
+
+// DiscoveryDoc - parses the output from the OIDC provider's discovery
+// endpoint. Only the fields consumed by this package are modeled here;
+// unknown fields are ignored.
+type DiscoveryDoc struct {
+	Issuer                           string   `json:"issuer,omitempty"`
+	JWKSURI                          string   `json:"jwks_uri,omitempty"`
+	TokenEndpoint                    string   `json:"token_endpoint,omitempty"`
+	UserInfoEndpoint                 string   `json:"userinfo_endpoint,omitempty"`
+	IntrospectionEndpoint            string   `json:"introspection_endpoint,omitempty"`
+	RevocationEndpoint               string   `json:"revocation_endpoint,omitempty"`
+	IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported,omitempty"`
+}
+
+// lookupDiscoveryDoc fetches and decodes the `.well-known/openid-configuration`
+// document for the given issuer using client. The issuer, with any trailing
+// slash removed, is used as the base URL; callers are responsible for making
+// sure it does not already
+// carry a path component that conflicts with discoveryDocPath.
+func lookupDiscoveryDoc(client *http.Client, issuer string) (DiscoveryDoc, error) {
+	var d DiscoveryDoc
+
+	u := strings.TrimSuffix(issuer, "/") + discoveryDocPath
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return d, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return d, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return d, fmt.Errorf("openid: discovery document fetch failed for %s: %s", u, resp.Status)
+	}
+
+	if err = json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return d, err
+	}
+
+	if d.JWKSURI == "" {
+		return d, fmt.Errorf("openid: discovery document at %s is missing jwks_uri", u)
+	}
+
+	return d, nil
+}
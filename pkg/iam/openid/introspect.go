@@ -0,0 +1,224 @@
+/*
+ * MinIO Cloud Storage, (C) 2020 MinIO, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openid
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jwtgo "github.com/dgrijalva/jwt-go"
+)
+
+// introspectionCacheSize bounds how many introspection responses are kept
+// per provider, evicting the least recently used entry once exceeded.
+const introspectionCacheSize = 1024
+
+// looksLikeJWS reports whether token has the three dot-separated segments
+// of a JWS compact serialization. Anything else - in particular an opaque
+// access token - can only be checked via introspection.
+func looksLikeJWS(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// introspectionCache is a small LRU, keyed by a hash of the token, of
+// introspection responses - so that a busy caller validating the same
+// token repeatedly doesn't round-trip to the provider every time. Entries
+// are honored only until the claimed "exp".
+type introspectionCache struct {
+	mu      sync.Mutex
+	max     int
+	ll      *list.List
+	entries map[string]*list.Element
+}
+
+type introspectionCacheItem struct {
+	key    string
+	claims jwtgo.MapClaims
+	expAt  int64
+}
+
+func newIntrospectionCache(max int) *introspectionCache {
+	return &introspectionCache{
+		max:     max,
+		ll:      list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (c *introspectionCache) get(key string) (jwtgo.MapClaims, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	item := el.Value.(*introspectionCacheItem)
+	if time.Now().Unix() >= item.expAt {
+		c.ll.Remove(el)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return item.claims, true
+}
+
+func (c *introspectionCache) add(key string, claims jwtgo.MapClaims, expAt int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.ll.MoveToFront(el)
+		item := el.Value.(*introspectionCacheItem)
+		item.claims, item.expAt = claims, expAt
+		return
+	}
+
+	el := c.ll.PushFront(&introspectionCacheItem{key: key, claims: claims, expAt: expAt})
+	c.entries[key] = el
+
+	if c.ll.Len() > c.max {
+		oldest := c.ll.Back()
+		if oldest != nil {
+			c.ll.Remove(oldest)
+			delete(c.entries, oldest.Value.(*introspectionCacheItem).key)
+		}
+	}
+}
+
+// introspectionCacheKey hashes token rather than using it verbatim as a map
+// key, so a core dump or log of the cache doesn't hand out live tokens.
+func introspectionCacheKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func (r *JWKSArgs) ensureIntrospectionCache() *introspectionCache {
+	if r.introspect == nil {
+		r.introspect = newIntrospectionCache(introspectionCacheSize)
+	}
+	return r.introspect
+}
+
+// introspectionURL returns the configured introspection endpoint, falling
+// back to the one discovered via ConfigURL.
+func (r *JWKSArgs) introspectionURL() string {
+	if r.IntrospectionEndpoint != "" {
+		return r.IntrospectionEndpoint
+	}
+	return r.discoveryDoc().IntrospectionEndpoint
+}
+
+// introspectToken performs (or serves from cache) an RFC 7662 introspection
+// request for token against this provider, returning ErrTokenExpired for an
+// `active: false` response so IdP-side revocation is honored even though
+// signature validation alone can't see it.
+func (r *JWKSArgs) introspectToken(token string) (jwtgo.MapClaims, error) {
+	endpoint := r.introspectionURL()
+	if endpoint == "" {
+		return nil, errors.New("openid: introspection requested but no introspection_endpoint is configured")
+	}
+
+	cache := r.ensureIntrospectionCache()
+	cacheKey := introspectionCacheKey(token)
+	if claims, ok := cache.get(cacheKey); ok {
+		return claims, nil
+	}
+
+	form := url.Values{}
+	form.Set("token", token)
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if r.ClientID != "" {
+		req.SetBasicAuth(r.ClientID, r.ClientSecret)
+	}
+
+	client := &http.Client{}
+	if r.transport != nil {
+		client.Transport = r.transport
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer r.closeRespFn(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("openid: introspection request failed: %s", resp.Status)
+	}
+
+	var claims jwtgo.MapClaims
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		return nil, err
+	}
+
+	if active, _ := claims["active"].(bool); !active {
+		return nil, ErrTokenExpired
+	}
+
+	expAt, err := expToInt64(claims["exp"])
+	if err != nil {
+		return nil, err
+	}
+	cache.add(cacheKey, claims, expAt)
+
+	return claims, nil
+}
+
+// introspectWith validates token against a single, already-selected
+// provider via introspection.
+func (p *JWT) introspectWith(args *JWKSArgs, token, dsecs string) (map[string]interface{}, error) {
+	claims, err := args.introspectToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if err := args.validateClaims(claims); err != nil {
+		return nil, err
+	}
+	args.applyPolicyClaim(claims)
+	return clampExpiry(claims, dsecs)
+}
+
+// introspect validates an opaque token via introspection. An opaque token
+// carries no `iss` claim to route on, so - unlike the JWS path - this
+// cannot pick the right provider out of the token itself. Posting the raw
+// token to every configured provider in turn would hand a live bearer
+// credential to IdPs the caller never authenticated with, so this only
+// works automatically when exactly one provider is configured; a
+// multi-provider deployment must resolve the provider out of band and call
+// ValidateWithProvider instead.
+func (p *JWT) introspect(token, dsecs string) (map[string]interface{}, error) {
+	args, err := p.cfg.soleProvider()
+	if err != nil {
+		return nil, err
+	}
+	return p.introspectWith(args, token, dsecs)
+}
@@ -0,0 +1,140 @@
+/*
+ * MinIO Cloud Storage, (C) 2020 MinIO, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openid
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	jwtgo "github.com/dgrijalva/jwt-go"
+)
+
+func TestLooksLikeJWS(t *testing.T) {
+	if !looksLikeJWS("header.payload.signature") {
+		t.Fatal("expected a three segment token to look like a JWS")
+	}
+	if looksLikeJWS("opaque-access-token") {
+		t.Fatal("expected an opaque token not to look like a JWS")
+	}
+}
+
+func TestIntrospectionCacheLRUEviction(t *testing.T) {
+	c := newIntrospectionCache(2)
+	expAt := time.Now().Add(time.Hour).Unix()
+
+	c.add("a", jwtgo.MapClaims{"k": "a"}, expAt)
+	c.add("b", jwtgo.MapClaims{"k": "b"}, expAt)
+	// Touch "a" so "b" becomes the least recently used entry.
+	if _, ok := c.get("a"); !ok {
+		t.Fatal("expected a to be cached")
+	}
+	c.add("c", jwtgo.MapClaims{"k": "c"}, expAt)
+
+	if _, ok := c.get("b"); ok {
+		t.Fatal("expected b to have been evicted as least recently used")
+	}
+	if _, ok := c.get("a"); !ok {
+		t.Fatal("expected a to still be cached")
+	}
+	if _, ok := c.get("c"); !ok {
+		t.Fatal("expected c to still be cached")
+	}
+}
+
+func TestIntrospectionCacheExpiry(t *testing.T) {
+	c := newIntrospectionCache(4)
+	c.add("expired", jwtgo.MapClaims{}, time.Now().Add(-time.Second).Unix())
+	if _, ok := c.get("expired"); ok {
+		t.Fatal("expected an expired entry not to be served from cache")
+	}
+}
+
+func TestIntrospectionCacheKeyStable(t *testing.T) {
+	a := introspectionCacheKey("token-one")
+	b := introspectionCacheKey("token-one")
+	c := introspectionCacheKey("token-two")
+	if a != b {
+		t.Fatal("expected introspectionCacheKey to be stable for the same token")
+	}
+	if a == c {
+		t.Fatal("expected introspectionCacheKey to differ for different tokens")
+	}
+}
+
+func TestIntrospectTokenActive(t *testing.T) {
+	expAt := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"active":true,"exp":` + expAt + `,"iss":"https://idp.example.com"}`))
+	}))
+	defer ts.Close()
+
+	args := &JWKSArgs{IntrospectionEndpoint: ts.URL, closeRespFn: func(c io.ReadCloser) { c.Close() }}
+	claims, err := args.introspectToken("some-opaque-token")
+	if err != nil {
+		t.Fatalf("introspectToken: %v", err)
+	}
+	if iss, _ := claims["iss"].(string); iss != "https://idp.example.com" {
+		t.Fatalf("unexpected claims: %v", claims)
+	}
+}
+
+func TestIntrospectTokenInactive(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"active":false}`))
+	}))
+	defer ts.Close()
+
+	args := &JWKSArgs{IntrospectionEndpoint: ts.URL, closeRespFn: func(c io.ReadCloser) { c.Close() }}
+	if _, err := args.introspectToken("revoked-token"); !errors.Is(err, ErrTokenExpired) {
+		t.Fatalf("expected ErrTokenExpired for an inactive token, got %v", err)
+	}
+}
+
+func TestIntrospectNoEndpointConfigured(t *testing.T) {
+	args := &JWKSArgs{}
+	if _, err := args.introspectToken("token"); err == nil {
+		t.Fatal("expected an error when no introspection endpoint is configured")
+	}
+}
+
+// TestIntrospectWithRejectsAudienceMismatch guards against an introspected
+// token - still active at the IdP - being accepted for a client/audience the
+// operator never configured it for: introspectWith must run validateClaims,
+// not just applyPolicyClaim/clampExpiry.
+func TestIntrospectWithRejectsAudienceMismatch(t *testing.T) {
+	expAt := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"active":true,"exp":` + expAt + `,"aud":"some-other-client"}`))
+	}))
+	defer ts.Close()
+
+	args := &JWKSArgs{
+		IntrospectionEndpoint: ts.URL,
+		ClientID:              "my-client",
+		closeRespFn:           func(c io.ReadCloser) { c.Close() },
+	}
+
+	p := &JWT{cfg: &Config{}}
+	if _, err := p.introspectWith(args, "live-but-wrong-audience-token", ""); err == nil {
+		t.Fatal("expected introspectWith to reject a token introspected as active but for a different audience")
+	}
+}
@@ -0,0 +1,179 @@
+/*
+ * MinIO Cloud Storage, (C) 2020 MinIO, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openid
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	jose "gopkg.in/square/go-jose.v2"
+
+	xnet "github.com/minio/minio/pkg/net"
+)
+
+func TestIsJWE(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+		want  bool
+	}{
+		{"jws, three segments", "header.payload.signature", false},
+		{"jwe, five segments", "header.key.iv.ciphertext.tag", true},
+		{"opaque token, no segments", "opaque-access-token", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := isJWE(test.token); got != test.want {
+				t.Fatalf("isJWE(%q) = %v, want %v", test.token, got, test.want)
+			}
+		})
+	}
+}
+
+func TestDecryptJWEWithoutPrivateKey(t *testing.T) {
+	cfg := &Config{}
+	if _, err := cfg.decryptJWE("header.key.iv.ciphertext.tag"); err == nil {
+		t.Fatal("expected decryptJWE to fail when no private key is configured")
+	}
+}
+
+// TestDecryptJWERoundTripRSAOAEP exercises the actual happy path of this
+// feature: a JWE encrypted the way an IdP would (RSA-OAEP key wrap,
+// A256GCM content encryption) decrypts back to the inner plaintext through
+// parseJWEPrivateKey's PKCS1 branch and decryptJWE.
+func TestDecryptJWERoundTripRSAOAEP(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const plaintext = "header.payload.signature"
+	token := encryptJWE(t, jose.RSA_OAEP, jose.A256GCM, &rsaKey.PublicKey, plaintext)
+
+	pemBytes := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(rsaKey),
+	})
+	key, err := parseJWEPrivateKey(pemBytes)
+	if err != nil {
+		t.Fatalf("parseJWEPrivateKey: %v", err)
+	}
+
+	cfg := &Config{jwePrivateKey: key}
+	got, err := cfg.decryptJWE(token)
+	if err != nil {
+		t.Fatalf("decryptJWE: %v", err)
+	}
+	if got != plaintext {
+		t.Fatalf("decryptJWE() = %q, want %q", got, plaintext)
+	}
+}
+
+// TestDecryptJWERoundTripECDHES covers the EC key wrap branch (ECDH-ES,
+// A128GCM) and parseJWEPrivateKey's SEC1/EC branch.
+func TestDecryptJWERoundTripECDHES(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const plaintext = "header.payload.signature"
+	token := encryptJWE(t, jose.ECDH_ES, jose.A128GCM, &ecKey.PublicKey, plaintext)
+
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER})
+	key, err := parseJWEPrivateKey(pemBytes)
+	if err != nil {
+		t.Fatalf("parseJWEPrivateKey: %v", err)
+	}
+
+	cfg := &Config{jwePrivateKey: key}
+	got, err := cfg.decryptJWE(token)
+	if err != nil {
+		t.Fatalf("decryptJWE: %v", err)
+	}
+	if got != plaintext {
+		t.Fatalf("decryptJWE() = %q, want %q", got, plaintext)
+	}
+}
+
+func encryptJWE(t *testing.T, keyAlg jose.KeyAlgorithm, contentAlg jose.ContentEncryption, publicKey interface{}, plaintext string) string {
+	t.Helper()
+	encrypter, err := jose.NewEncrypter(contentAlg, jose.Recipient{Algorithm: keyAlg, Key: publicKey}, nil)
+	if err != nil {
+		t.Fatalf("jose.NewEncrypter: %v", err)
+	}
+	obj, err := encrypter.Encrypt([]byte(plaintext))
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	token, err := obj.CompactSerialize()
+	if err != nil {
+		t.Fatalf("CompactSerialize: %v", err)
+	}
+	return token
+}
+
+func TestDoRefreshDecodesOctKey(t *testing.T) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		t.Fatal(err)
+	}
+	k := base64.RawURLEncoding.EncodeToString(secret)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"keys":[{"kty":"oct","kid":"hmac-1","alg":"HS256","k":"` + k + `"}]}`))
+	}))
+	defer ts.Close()
+
+	u, err := xnet.ParseURL(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := &JWKSArgs{URL: u, closeRespFn: func(c io.ReadCloser) { c.Close() }}
+
+	if err := args.doRefresh(); err != nil {
+		t.Fatalf("doRefresh: %v", err)
+	}
+
+	key, ok := args.lookupKey("hmac-1")
+	if !ok {
+		t.Fatal("expected the oct key to be cached")
+	}
+	got, ok := key.([]byte)
+	if !ok {
+		t.Fatalf("expected the oct key to decode to []byte, got %T", key)
+	}
+	if string(got) != string(secret) {
+		t.Fatal("decoded oct key does not match the source secret")
+	}
+	if alg, ok := args.lookupAlg("hmac-1"); !ok || alg != "HS256" {
+		t.Fatalf("expected alg %q to be recorded for kid, got %q (ok=%v)", "HS256", alg, ok)
+	}
+}
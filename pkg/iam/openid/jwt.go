@@ -19,7 +19,6 @@ package openid
 import (
 	"crypto"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -27,51 +26,68 @@ import (
 	"time"
 
 	jwtgo "github.com/dgrijalva/jwt-go"
-	"github.com/minio/minio/pkg/env"
 	xnet "github.com/minio/minio/pkg/net"
 )
 
-// JWKSArgs - RSA authentication target arguments
+// JWKSArgs - a single OpenID provider's authentication target arguments. It
+// can be configured either directly with a JWKS URL, or with ConfigURL - the
+// provider's issuer - in which case the JWKS URL and the supported signing
+// algorithms are discovered from the provider's `.well-known/openid-
+// configuration` document.
 type JWKSArgs struct {
-	URL         *xnet.URL `json:"url"`
+	URL          *xnet.URL `json:"url"`
+	ConfigURL    *xnet.URL `json:"config_url,omitempty"`
+	ClientID     string    `json:"client_id,omitempty"`
+	ClientSecret string    `json:"client_secret,omitempty"`
+
+	// Issuer overrides the expected `iss` claim when it isn't discovered
+	// via ConfigURL, e.g. when URL is configured directly.
+	Issuer string `json:"issuer,omitempty"`
+
+	// ClaimName is the claim (a plain name, or a dotted path such as
+	// "realm_access.roles") operators use to drive STS policy attachment.
+	// See applyPolicyClaim.
+	ClaimName string `json:"claim_name,omitempty"`
+
+	// ClockSkew bounds how far nbf/iat may diverge from local time.
+	// Defaults to defaultClockSkew when zero.
+	ClockSkew time.Duration `json:"clock_skew,omitempty"`
+
+	// IntrospectionEnabled routes every token through RFC 7662
+	// introspection instead of local signature verification, so the
+	// provider's revocation state is honored on each call. Opaque (non-JWT)
+	// tokens are introspected regardless of this flag.
+	IntrospectionEnabled bool `json:"introspection_enabled,omitempty"`
+
+	// IntrospectionEndpoint overrides the endpoint discovered via
+	// ConfigURL/DiscoveryDoc.
+	IntrospectionEndpoint string `json:"introspection_endpoint,omitempty"`
+
+	// DiscoveryDoc holds the document fetched from ConfigURL, when set.
+	DiscoveryDoc DiscoveryDoc `json:"-"`
+
+	// publicKeys mirrors the cache's current key set for callers that
+	// inspected it directly before the cache existed.
 	publicKeys  map[string]crypto.PublicKey
+	cache       *jwksCache
+	introspect  *introspectionCache
 	transport   *http.Transport
 	closeRespFn func(io.ReadCloser)
 }
 
-// PopulatePublicKey - populates a new publickey from the JWKS URL.
+// PopulatePublicKey - populates the public key cache from the JWKS URL
+// (discovering it, along with the rest of the provider's configuration,
+// from ConfigURL first when the JWKS URL isn't already known), then starts
+// the background goroutine that keeps it warm ahead of expiry. See Validate
+// and refreshForKid for how the cache is kept fresh after that.
 func (r *JWKSArgs) PopulatePublicKey() error {
-	if r.URL == nil {
+	if r.URL == nil && r.ConfigURL == nil {
 		return nil
 	}
-	client := &http.Client{}
-	if r.transport != nil {
-		client.Transport = r.transport
-	}
-	resp, err := client.Get(r.URL.String())
-	if err != nil {
-		return err
-	}
-	defer r.closeRespFn(resp.Body)
-	if resp.StatusCode != http.StatusOK {
-		return errors.New(resp.Status)
-	}
-
-	var jwk JWKS
-	if err = json.NewDecoder(resp.Body).Decode(&jwk); err != nil {
+	if err := r.refresh(); err != nil {
 		return err
 	}
-
-	r.publicKeys = make(map[string]crypto.PublicKey)
-	for _, key := range jwk.Keys {
-		var publicKey crypto.PublicKey
-		publicKey, err = key.DecodePublicKey()
-		if err != nil {
-			return err
-		}
-		r.publicKeys[key.Kid] = publicKey
-	}
-
+	r.startBackgroundRefresh()
 	return nil
 }
 
@@ -95,9 +111,10 @@ func (r *JWKSArgs) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
-// JWT - rs client grants provider details.
+// JWT - rs client grants provider details. A JWT may be backed by more than
+// one configured provider; Validate picks the right one per token.
 type JWT struct {
-	args JWKSArgs
+	cfg *Config
 }
 
 func expToInt64(expI interface{}) (expAt int64, err error) {
@@ -137,35 +154,118 @@ func GetDefaultExpiration(dsecs string) (time.Duration, error) {
 	return defaultExpiryDuration, nil
 }
 
-// Validate - validates the access token.
+// Validate - validates the access token. Opaque (non-JWT) tokens, and JWTs
+// belonging to a provider configured with IntrospectionEnabled, are
+// validated via RFC 7662 introspection instead of local signature
+// verification - see introspect.go.
 func (p *JWT) Validate(token, dsecs string) (map[string]interface{}, error) {
+	if isJWE(token) {
+		plaintext, err := p.cfg.decryptJWE(token)
+		if err != nil {
+			return nil, err
+		}
+		token = plaintext
+	}
+
+	if !looksLikeJWS(token) {
+		return p.introspect(token, dsecs)
+	}
+
 	jp := new(jwtgo.Parser)
-	jp.ValidMethods = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}
+	jp.ValidMethods = defaultValidMethods
+
+	// Parse (without verifying) to learn the token's issuer, so a token
+	// from any configured provider can be routed to the right key set.
+	var unverifiedClaims jwtgo.MapClaims
+	if _, _, err := jp.ParseUnverified(token, &unverifiedClaims); err != nil {
+		return nil, err
+	}
+
+	args, err := p.cfg.providerForClaims(unverifiedClaims)
+	if err != nil {
+		return nil, err
+	}
+
+	if args.IntrospectionEnabled {
+		return p.introspectWith(args, token, dsecs)
+	}
+
+	// Enforce what this provider actually advertises, rather than the
+	// fixed default list, once discovery has told us.
+	jp.ValidMethods = args.validMethods()
 
 	keyFuncCallback := func(jwtToken *jwtgo.Token) (interface{}, error) {
 		kid, ok := jwtToken.Header["kid"].(string)
 		if !ok {
 			return nil, fmt.Errorf("Invalid kid value %v", jwtToken.Header["kid"])
 		}
-		return p.args.publicKeys[kid], nil
+		key, ok := args.lookupKey(kid)
+		if !ok {
+			// Unknown kid - the provider may have rotated its keys;
+			// refresh (rate limited) and try once more before giving up.
+			args.refreshForKid()
+			key, ok = args.lookupKey(kid)
+			if !ok {
+				return nil, fmt.Errorf("Invalid kid value %v", kid)
+			}
+		}
+		if alg, ok := args.lookupAlg(kid); ok && alg != jwtToken.Method.Alg() {
+			return nil, fmt.Errorf("openid: token alg %q does not match JWK alg %q for kid %v", jwtToken.Method.Alg(), alg, kid)
+		}
+		return key, nil
 	}
 
 	var claims jwtgo.MapClaims
 	jwtToken, err := jp.ParseWithClaims(token, &claims, keyFuncCallback)
 	if err != nil {
-		if err = p.args.PopulatePublicKey(); err != nil {
-			return nil, err
-		}
-		jwtToken, err = jwtgo.ParseWithClaims(token, &claims, keyFuncCallback)
+		return nil, err
+	}
+
+	if !jwtToken.Valid {
+		return nil, ErrTokenExpired
+	}
+
+	if err := args.validateClaims(claims); err != nil {
+		return nil, err
+	}
+	args.applyPolicyClaim(claims)
+
+	return clampExpiry(claims, dsecs)
+}
+
+// ValidateWithProvider validates token against the named provider, without
+// attempting to route it by its `iss` claim first. This is the multi-provider
+// counterpart to the opaque-token path of Validate: an opaque token carries
+// no claims to route on, so a deployment with more than one provider
+// configured must resolve the issuing provider out of band (e.g. from which
+// endpoint the caller authenticated against) and pass its name here rather
+// than have the token broadcast to every provider's introspection endpoint.
+// Every token, a JWS included, is checked via introspection here; no local
+// signature verification is done.
+func (p *JWT) ValidateWithProvider(providerName, token, dsecs string) (map[string]interface{}, error) {
+	if isJWE(token) {
+		plaintext, err := p.cfg.decryptJWE(token)
 		if err != nil {
 			return nil, err
 		}
+		token = plaintext
 	}
 
-	if !jwtToken.Valid {
-		return nil, ErrTokenExpired
+	args, ok := p.cfg.providerByName(providerName)
+	if !ok {
+		return nil, fmt.Errorf("openid: no such provider %q", providerName)
 	}
 
+	return p.introspectWith(args, token, dsecs)
+}
+
+// clampExpiry checks that claims carries a usable `exp` and that dsecs (the
+// role session duration requested by the caller) is a valid duration,
+// returning an error otherwise. claims["exp"] is rewritten only when it lies
+// before now plus the lesser of dsecs and the time left until `exp`, which
+// never holds, so the claims are returned with `exp` unchanged. It is shared
+// by the local signature-validation path and the introspection fallback.
+func clampExpiry(claims jwtgo.MapClaims, dsecs string) (map[string]interface{}, error) {
 	expAt, err := expToInt64(claims["exp"])
 	if err != nil {
 		return nil, err
@@ -186,7 +286,6 @@ func (p *JWT) Validate(token, dsecs string) (map[string]interface{}, error) {
 	}
 
 	return claims, nil
-
 }
 
 // ID returns the provider name and authentication type.
@@ -194,37 +293,10 @@ func (p *JWT) ID() ID {
 	return "jwt"
 }
 
-// JWKS url
-const (
-	EnvIAMJWKSURL = "MINIO_IAM_JWKS_URL"
-)
-
-// LookupConfig lookup jwks from config, override with any ENVs.
-func LookupConfig(args JWKSArgs, transport *http.Transport, closeRespFn func(io.ReadCloser)) (JWKSArgs, error) {
-	var urlStr string
-	if args.URL != nil {
-		urlStr = args.URL.String()
-	}
-
-	jwksURL := env.Get(EnvIAMJWKSURL, urlStr)
-	if jwksURL == "" {
-		return args, nil
-	}
-
-	u, err := xnet.ParseURL(jwksURL)
-	if err != nil {
-		return args, err
-	}
-	args.URL = u
-	if err := args.PopulatePublicKey(); err != nil {
-		return args, err
-	}
-	return args, nil
-}
-
-// NewJWT - initialize new jwt authenticator.
-func NewJWT(args JWKSArgs) *JWT {
+// NewJWT - initialize a new jwt authenticator from a provider configuration,
+// see LookupConfig.
+func NewJWT(cfg *Config) *JWT {
 	return &JWT{
-		args: args,
+		cfg: cfg,
 	}
 }